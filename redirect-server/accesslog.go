@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// accessLogErrInterval rate-limits write-failure logging: an unwritable
+// AccessLog.Path fails on every request, and without this the
+// operational log would get one line per request instead of one per
+// interval.
+const accessLogErrInterval = time.Minute
+
+// AccessLogger records one structured entry per request to a rotating,
+// gzip-compressed log file, separate from the operational log written
+// via the standard log package.
+type AccessLogger struct {
+	format  string
+	writer  *lumberjack.Logger
+	proxies []*net.IPNet
+
+	// lastWriteErrLogged is the UnixNano time a write failure was last
+	// reported via the operational logger.
+	lastWriteErrLogged atomic.Int64
+}
+
+// NewAccessLogger builds an AccessLogger from cfg, parsing its trusted
+// proxy CIDRs up front.
+func NewAccessLogger(cfg AccessLogConfig) (*AccessLogger, error) {
+	proxies := make([]*net.IPNet, 0, len(cfg.TrustedProxies))
+	for _, cidr := range cfg.TrustedProxies {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted_proxies entry %q: %w", cidr, err)
+		}
+		proxies = append(proxies, network)
+	}
+
+	return &AccessLogger{
+		format: cfg.Format,
+		writer: &lumberjack.Logger{
+			Filename:   cfg.Path,
+			MaxSize:    cfg.MaxSizeMB,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     cfg.MaxAgeDays,
+			Compress:   true,
+		},
+		proxies: proxies,
+	}, nil
+}
+
+// accessLogEntry is one structured access log record.
+type accessLogEntry struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	RemoteAddr string    `json:"remote_addr"`
+	UserAgent  string    `json:"user_agent"`
+	Status     int       `json:"status"`
+	Target     string    `json:"target,omitempty"`
+	LatencyMs  float64   `json:"latency_ms"`
+}
+
+// Middleware wraps next, recording one access log entry per request
+// without altering the response it produces.
+func (l *AccessLogger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		l.write(accessLogEntry{
+			Time:       start,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			RemoteAddr: l.clientIP(r),
+			UserAgent:  r.UserAgent(),
+			Status:     rec.status,
+			Target:     rec.Header().Get("Location"),
+			LatencyMs:  float64(time.Since(start)) / float64(time.Millisecond),
+		})
+	})
+}
+
+func (l *AccessLogger) write(entry accessLogEntry) {
+	if l.format == "combined" {
+		if _, err := fmt.Fprintf(l.writer, "%s - - [%s] %q %d %q %q %.3f\n",
+			entry.RemoteAddr, entry.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			entry.Method+" "+entry.Path+" HTTP/1.1", entry.Status, entry.Target, entry.UserAgent, entry.LatencyMs); err != nil {
+			l.logWriteError(err)
+		}
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if _, err := l.writer.Write(append(data, '\n')); err != nil {
+		l.logWriteError(err)
+	}
+}
+
+// logWriteError reports a failed access log write via the operational
+// logger, at most once per accessLogErrInterval so a persistently
+// unwritable AccessLog.Path doesn't flood the log.
+func (l *AccessLogger) logWriteError(err error) {
+	now := time.Now().UnixNano()
+	last := l.lastWriteErrLogged.Load()
+	if now-last < int64(accessLogErrInterval) {
+		return
+	}
+	if !l.lastWriteErrLogged.CompareAndSwap(last, now) {
+		return
+	}
+	log.Printf("access log: failed to write entry: %v", err)
+}
+
+// clientIP resolves the request's remote IP, honoring X-Forwarded-For
+// only when the direct peer address matches one of the configured
+// trusted proxy CIDRs.
+func (l *AccessLogger) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if !l.isTrustedProxy(host) {
+		return host
+	}
+
+	xff := r.Header.Get("X-Forwarded-For")
+	if xff == "" {
+		return host
+	}
+
+	first, _, _ := strings.Cut(xff, ",")
+	return strings.TrimSpace(first)
+}
+
+func (l *AccessLogger) isTrustedProxy(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range l.proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// statusRecorder captures the status code written by the wrapped
+// handler so Middleware can include it in the access log entry.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/sync/singleflight"
+)
+
+// acmeChallengePrefix is the well-known path ACME HTTP-01 validation
+// requests arrive on.
+const acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+// acmeRenewBefore is how far ahead of expiry a certificate is renewed.
+const acmeRenewBefore = 30 * 24 * time.Hour
+
+// ACMEManager provisions and caches TLS certificates for an allowlist of
+// hostnames via the ACME protocol (HTTP-01 challenge only), persisting
+// issued certificates under ACMECacheDir and renewing them in the
+// background as they approach expiry.
+type ACMEManager struct {
+	client   *acme.Client
+	hosts    map[string]bool
+	cacheDir string
+
+	mu    sync.Mutex
+	certs map[string]*tls.Certificate
+
+	// issueGroup single-flights obtainCertificate by host, so a burst of
+	// concurrent handshakes for a not-yet-cached hostname results in one
+	// ACME order instead of one per connection.
+	issueGroup singleflight.Group
+
+	challengeMu sync.Mutex
+	challenges  map[string]string // token -> key authorization
+}
+
+// NewACMEManager registers an ACME account with cfg.ACMEDirectoryURL,
+// loads any certificates already cached on disk, and starts the
+// background renewal loop.
+func NewACMEManager(cfg SSLConfig) (*ACMEManager, error) {
+	if err := os.MkdirAll(cfg.ACMECacheDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create ACME cache dir: %w", err)
+	}
+
+	accountKey, err := loadOrCreateAccountKey(cfg.ACMECacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load or create ACME account key: %w", err)
+	}
+
+	hosts := make(map[string]bool, len(cfg.ACMEHosts))
+	for _, h := range cfg.ACMEHosts {
+		hosts[strings.ToLower(h)] = true
+	}
+
+	m := &ACMEManager{
+		client: &acme.Client{
+			Key:          accountKey,
+			DirectoryURL: cfg.ACMEDirectoryURL,
+		},
+		hosts:      hosts,
+		cacheDir:   cfg.ACMECacheDir,
+		certs:      make(map[string]*tls.Certificate),
+		challenges: make(map[string]string),
+	}
+
+	account := &acme.Account{}
+	if cfg.ACMEEmail != "" {
+		account.Contact = []string{"mailto:" + cfg.ACMEEmail}
+	}
+	if _, err := m.client.Register(context.Background(), account, acme.AcceptTOS); err != nil && err != acme.ErrAccountAlreadyExists {
+		return nil, fmt.Errorf("failed to register ACME account: %w", err)
+	}
+
+	m.loadCachedCerts()
+
+	go m.renewLoop()
+
+	return m, nil
+}
+
+// ChallengeHandler serves ACME HTTP-01 challenge responses. Register it
+// ahead of the catch-all redirect handler so validation requests never
+// fall through to redirectHandler.
+func (m *ACMEManager) ChallengeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.URL.Path, acmeChallengePrefix)
+
+		m.challengeMu.Lock()
+		keyAuth, ok := m.challenges[token]
+		m.challengeMu.Unlock()
+
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(keyAuth))
+	})
+}
+
+// GetCertificate is used as tls.Config.GetCertificate: it serves a cached
+// certificate for hello.ServerName when one is fresh, otherwise it blocks
+// obtaining one via ACME.
+func (m *ACMEManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := strings.ToLower(hello.ServerName)
+	if host == "" || !m.hosts[host] {
+		return nil, fmt.Errorf("acme: host %q is not in the configured allowlist", host)
+	}
+
+	m.mu.Lock()
+	cert := m.certs[host]
+	m.mu.Unlock()
+
+	if certValidFor(cert, acmeRenewBefore) {
+		return cert, nil
+	}
+
+	return m.issueCertificate(context.Background(), host)
+}
+
+// issueCertificate obtains a certificate for host via ACME, single-flighting
+// concurrent callers for the same host so they wait on one issuance instead
+// of each starting their own order.
+func (m *ACMEManager) issueCertificate(ctx context.Context, host string) (*tls.Certificate, error) {
+	v, err, _ := m.issueGroup.Do(host, func() (any, error) {
+		return m.obtainCertificate(ctx, host)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*tls.Certificate), nil
+}
+
+// obtainCertificate runs the ACME HTTP-01 order flow for host and caches
+// the resulting certificate, both in memory and on disk.
+func (m *ACMEManager) obtainCertificate(ctx context.Context, host string) (*tls.Certificate, error) {
+	order, err := m.client.AuthorizeOrder(ctx, acme.DomainIDs(host))
+	if err != nil {
+		return nil, fmt.Errorf("acme: authorize order for %s: %w", host, err)
+	}
+
+	for _, authzURL := range order.AuthzURLs {
+		authz, err := m.client.GetAuthorization(ctx, authzURL)
+		if err != nil {
+			return nil, fmt.Errorf("acme: get authorization for %s: %w", host, err)
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+
+		if err := m.solveHTTP01(ctx, authz, authzURL, host); err != nil {
+			return nil, err
+		}
+	}
+
+	order, err = m.client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, fmt.Errorf("acme: wait order for %s: %w", host, err)
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("acme: generate leaf key for %s: %w", host, err)
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: host},
+		DNSNames: []string{host},
+	}, leafKey)
+	if err != nil {
+		return nil, fmt.Errorf("acme: create CSR for %s: %w", host, err)
+	}
+
+	der, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("acme: finalize order for %s: %w", host, err)
+	}
+
+	leaf, err := x509.ParseCertificate(der[0])
+	if err != nil {
+		return nil, fmt.Errorf("acme: parse issued certificate for %s: %w", host, err)
+	}
+
+	cert := &tls.Certificate{
+		Certificate: der,
+		PrivateKey:  leafKey,
+		Leaf:        leaf,
+	}
+
+	if err := m.cacheCertificate(host, cert); err != nil {
+		log.Printf("acme: failed to cache certificate for %s: %v", host, err)
+	}
+
+	m.mu.Lock()
+	m.certs[host] = cert
+	m.mu.Unlock()
+
+	log.Printf("acme: issued certificate for %s, valid until %s", host, leaf.NotAfter.Format(time.RFC3339))
+
+	return cert, nil
+}
+
+// solveHTTP01 answers the http-01 challenge of authz and waits for the CA
+// to mark it valid.
+func (m *ACMEManager) solveHTTP01(ctx context.Context, authz *acme.Authorization, authzURL, host string) error {
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "http-01" {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("acme: no http-01 challenge offered for %s", host)
+	}
+
+	keyAuth, err := m.client.HTTP01ChallengeResponse(chal.Token)
+	if err != nil {
+		return fmt.Errorf("acme: build http-01 response for %s: %w", host, err)
+	}
+
+	m.challengeMu.Lock()
+	m.challenges[chal.Token] = keyAuth
+	m.challengeMu.Unlock()
+	defer func() {
+		m.challengeMu.Lock()
+		delete(m.challenges, chal.Token)
+		m.challengeMu.Unlock()
+	}()
+
+	if _, err := m.client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("acme: accept http-01 challenge for %s: %w", host, err)
+	}
+	if _, err := m.client.WaitAuthorization(ctx, authzURL); err != nil {
+		return fmt.Errorf("acme: wait authorization for %s: %w", host, err)
+	}
+
+	return nil
+}
+
+// renewLoop periodically re-issues certificates that are within
+// acmeRenewBefore of expiry.
+func (m *ACMEManager) renewLoop() {
+	ticker := time.NewTicker(12 * time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.mu.Lock()
+		var stale []string
+		for host, cert := range m.certs {
+			if !certValidFor(cert, acmeRenewBefore) {
+				stale = append(stale, host)
+			}
+		}
+		m.mu.Unlock()
+
+		for _, host := range stale {
+			log.Printf("acme: renewing certificate for %s", host)
+			if _, err := m.issueCertificate(context.Background(), host); err != nil {
+				log.Printf("acme: renewal failed for %s: %v", host, err)
+			}
+		}
+	}
+}
+
+// cacheCertificate persists cert as a PEM cert/key pair keyed by host
+// under m.cacheDir.
+func (m *ACMEManager) cacheCertificate(host string, cert *tls.Certificate) error {
+	key, ok := cert.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return fmt.Errorf("acme: unexpected private key type %T", cert.PrivateKey)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	var certPEM []byte
+	for _, der := range cert.Certificate {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	if err := os.WriteFile(filepath.Join(m.cacheDir, host+".crt"), certPEM, 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(m.cacheDir, host+".key"), keyPEM, 0o600)
+}
+
+// loadOrCreateAccountKey loads the ACME account key persisted under
+// cacheDir, generating and persisting a new one the first time it's
+// called. Reusing the same account key across restarts means the
+// process keeps registering against the same ACME account instead of
+// creating a brand-new one every time it restarts, which would
+// otherwise burn through the CA's new-account rate limit on a
+// crash-loop.
+func loadOrCreateAccountKey(cacheDir string) (*ecdsa.PrivateKey, error) {
+	path := filepath.Join(cacheDir, "account.key")
+
+	if keyPEM, err := os.ReadFile(path); err == nil {
+		block, _ := pem.Decode(keyPEM)
+		if block == nil {
+			return nil, fmt.Errorf("invalid PEM in %s", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate account key: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(path, keyPEM, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to persist account key: %w", err)
+	}
+
+	return key, nil
+}
+
+// loadCachedCerts populates m.certs from any cert/key pairs already on
+// disk for the configured hosts.
+func (m *ACMEManager) loadCachedCerts() {
+	for host := range m.hosts {
+		certPEM, err := os.ReadFile(filepath.Join(m.cacheDir, host+".crt"))
+		if err != nil {
+			continue
+		}
+		keyPEM, err := os.ReadFile(filepath.Join(m.cacheDir, host+".key"))
+		if err != nil {
+			continue
+		}
+
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			log.Printf("acme: discarding invalid cached certificate for %s: %v", host, err)
+			continue
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			log.Printf("acme: discarding unparsable cached certificate for %s: %v", host, err)
+			continue
+		}
+		cert.Leaf = leaf
+
+		m.mu.Lock()
+		m.certs[host] = &cert
+		m.mu.Unlock()
+	}
+}
+
+// certValidFor reports whether cert is non-nil and still valid at least
+// margin from now.
+func certValidFor(cert *tls.Certificate, margin time.Duration) bool {
+	if cert == nil || cert.Leaf == nil {
+		return false
+	}
+	return time.Now().Add(margin).Before(cert.Leaf.NotAfter)
+}
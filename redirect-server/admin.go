@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	lookupHits   atomic.Int64
+	lookupMisses atomic.Int64
+)
+
+// reloadMappings re-reads mappingFile, rebuilds the path lookup, and
+// atomically swaps it in. On any error the previous lookup is left in
+// place, so a bad mapping file can never take the server down.
+func reloadMappings() (oldCount, newCount int, err error) {
+	oldCount = pathLookupPtr.Load().Len()
+
+	mappingData, err := LoadMappingData(mappingFile)
+	if err != nil {
+		return oldCount, 0, fmt.Errorf("failed to load mapping data: %w", err)
+	}
+
+	newLookup, err := BuildPathLookup(mappingData)
+	if err != nil {
+		return oldCount, 0, fmt.Errorf("failed to build path lookup: %w", err)
+	}
+	pathLookupPtr.Store(&newLookup)
+
+	return oldCount, newLookup.Len(), nil
+}
+
+// newAdminMux builds the admin API on its own ServeMux, distinct from the
+// one serving subscription redirects. main mounts it at /_admin/ on that
+// redirect mux, so while the admin API is enabled, subscription paths
+// under /_admin/ reach this mux instead of redirectHandler. Every route
+// requires the configured bearer token.
+func newAdminMux(token string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_admin/reload", requireAdminToken(token, handleAdminReload))
+	mux.HandleFunc("/_admin/stats", requireAdminToken(token, handleAdminStats))
+	mux.HandleFunc("/_admin/mappings/", requireAdminToken(token, handleAdminMappingLookup))
+	return mux
+}
+
+// requireAdminToken rejects requests that don't present token as a
+// bearer credential before delegating to next.
+func requireAdminToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const prefix = "Bearer "
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, prefix) || subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleAdminReload re-runs reloadMappings and reports the old/new entry
+// counts as JSON.
+func handleAdminReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	oldCount, newCount, err := reloadMappings()
+	if err != nil {
+		log.Printf("Admin reload failed: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Admin reload: %d -> %d entries", oldCount, newCount)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"old_entries": oldCount,
+		"new_entries": newCount,
+	})
+}
+
+// handleAdminMappingLookup reports what the current path lookup resolves
+// a given path to, for reload debugging.
+func handleAdminMappingLookup(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/_admin/mappings/")
+	if path == "" {
+		http.Error(w, "missing path", http.StatusBadRequest)
+		return
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	newURL, found := pathLookupPtr.Load().Resolve(path)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"path":   path,
+		"found":  found,
+		"target": newURL,
+	})
+}
+
+// handleAdminStats reports the current lookup size and cumulative
+// hit/miss counters maintained by redirectHandler.
+func handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	entries := pathLookupPtr.Load().Len()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"entries": entries,
+		"hits":    lookupHits.Load(),
+		"misses":  lookupMisses.Load(),
+	})
+}
@@ -4,31 +4,101 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"strings"
 )
 
 // SSLConfig holds SSL certificate configuration
 type SSLConfig struct {
-	Enabled bool   `json:"enabled"`
-	Cert    string `json:"cert"`
-	Key     string `json:"key"`
+	Enabled bool `json:"enabled"`
+	// Mode selects how certificates are obtained: "manual" (embedded cert/key,
+	// the default), "acme" (automatic provisioning via ACME), or "selfsigned"
+	// (ephemeral dev CA).
+	Mode string `json:"mode,omitempty"`
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+
+	// ACME settings, used when Mode == "acme".
+	ACMEEmail        string   `json:"acme_email,omitempty"`
+	ACMEDirectoryURL string   `json:"acme_directory_url,omitempty"`
+	ACMECacheDir     string   `json:"acme_cache_dir,omitempty"`
+	ACMEHosts        []string `json:"acme_hosts,omitempty"`
 }
 
+// SSL modes supported by SSLConfig.Mode.
+const (
+	SSLModeManual     = "manual"
+	SSLModeACME       = "acme"
+	SSLModeSelfSigned = "selfsigned"
+)
+
+// LetsEncryptDirectoryURL and LetsEncryptStagingURL are the well-known ACME
+// directory endpoints for Let's Encrypt.
+const (
+	LetsEncryptDirectoryURL = "https://acme-v02.api.letsencrypt.org/directory"
+	LetsEncryptStagingURL   = "https://acme-staging-v02.api.letsencrypt.org/directory"
+)
+
 // ServerConfig holds server configuration
 type ServerConfig struct {
 	Host           string    `json:"host"`
 	Port           int       `json:"port"`
 	RedirectDomain string    `json:"redirect_domain"`
 	SSL            SSLConfig `json:"ssl"`
+
+	// AdminToken, when set, enables the /_admin/* API and is compared
+	// against the bearer token on every admin request. Leave empty to
+	// disable the admin API entirely.
+	AdminToken string `json:"admin_token,omitempty"`
+
+	AccessLog AccessLogConfig `json:"access_log,omitempty"`
+
+	// LameDuckSeconds is how long the server keeps draining (failing
+	// /healthz while still serving requests) after a shutdown signal,
+	// before Shutdown is actually called. Defaults to 5.
+	LameDuckSeconds int `json:"lame_duck_seconds,omitempty"`
+
+	// RunAsUser, when set, drops the process to this user (and its
+	// primary group) right after the listener is bound, before Serve is
+	// called. Lets the server bind a privileged port like 443 without
+	// running as root for the rest of its life.
+	RunAsUser string `json:"run_as_user,omitempty"`
+}
+
+// AccessLogConfig controls the structured, rotating access log that
+// records every request handled by redirectHandler. It is independent
+// of the operational log written via the standard log package.
+type AccessLogConfig struct {
+	Enabled bool   `json:"enabled"`
+	Path    string `json:"path"`
+	// Format is "json" (the default) or "combined" (Apache/nginx-style).
+	Format     string `json:"format,omitempty"`
+	MaxSizeMB  int    `json:"max_size_mb,omitempty"`
+	MaxBackups int    `json:"max_backups,omitempty"`
+	MaxAgeDays int    `json:"max_age_days,omitempty"`
+	// TrustedProxies lists CIDRs whose X-Forwarded-For header is trusted
+	// when determining the client IP.
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
 }
 
 // UserMapping holds the mapping for a single user
 type UserMapping struct {
-	UserID              int    `json:"user_id"`
-	OldSubscriptionURL  string `json:"old_subscription_url"`
-	NewSubscriptionURL  string `json:"new_subscription_url"`
-	UsernamePasarguard  string `json:"username_pasarguard,omitempty"`
-	MatchedBy           string `json:"matched_by,omitempty"`
+	UserID             int    `json:"user_id"`
+	OldSubscriptionURL string `json:"old_subscription_url"`
+	NewSubscriptionURL string `json:"new_subscription_url"`
+	UsernamePasarguard string `json:"username_pasarguard,omitempty"`
+	MatchedBy          string `json:"matched_by,omitempty"`
+
+	// MatchType selects how OldSubscriptionURL's path is matched against
+	// incoming requests: "exact" (the default), "prefix", or "regex". For
+	// "regex", NewSubscriptionURL may reference capture groups using
+	// regexp.Expand syntax, e.g. "/sub/NEWKEY/$1".
+	MatchType string `json:"match_type,omitempty"`
+
+	// Priority controls precedence among overlapping regex rules (and
+	// prefix rules of equal length): higher values are tried first. Rules
+	// left at the default of 0 fall back to a deterministic order sorted
+	// by mapping key, not the order they happen to appear in the file, so
+	// set Priority explicitly on any rule that must win over another.
+	Priority int `json:"priority,omitempty"`
 }
 
 // MappingData holds the URL mapping data
@@ -42,9 +112,6 @@ type MappingData struct {
 	NotFound      map[string]UserMapping `json:"not_found,omitempty"`
 }
 
-// PathLookup is a reverse lookup map from old path to new URL
-type PathLookup map[string]string
-
 // LoadServerConfig loads the server configuration from a JSON file
 func LoadServerConfig(filename string) (*ServerConfig, error) {
 	data, err := os.ReadFile(filename)
@@ -63,9 +130,53 @@ func LoadServerConfig(filename string) (*ServerConfig, error) {
 	}
 
 	if config.SSL.Enabled {
-		if config.SSL.Cert == "" || config.SSL.Key == "" {
-			return nil, fmt.Errorf("SSL enabled but cert or key is empty")
+		switch config.SSL.Mode {
+		case "", SSLModeManual:
+			config.SSL.Mode = SSLModeManual
+			if config.SSL.Cert == "" || config.SSL.Key == "" {
+				return nil, fmt.Errorf("SSL enabled but cert or key is empty")
+			}
+		case SSLModeACME:
+			if len(config.SSL.ACMEHosts) == 0 {
+				return nil, fmt.Errorf("SSL mode acme requires at least one entry in acme_hosts")
+			}
+			if config.SSL.ACMEDirectoryURL == "" {
+				config.SSL.ACMEDirectoryURL = LetsEncryptDirectoryURL
+			}
+			if config.SSL.ACMECacheDir == "" {
+				config.SSL.ACMECacheDir = "acme-cache"
+			}
+		case SSLModeSelfSigned:
+			// No cert/key required: certificates are minted on demand by an
+			// ephemeral in-memory CA, see SelfSignedManager in selfsigned.go.
+		default:
+			return nil, fmt.Errorf("unknown SSL mode: %q", config.SSL.Mode)
+		}
+	}
+
+	if config.AccessLog.Enabled {
+		if config.AccessLog.Path == "" {
+			return nil, fmt.Errorf("access log enabled but path is empty")
+		}
+		if config.AccessLog.Format == "" {
+			config.AccessLog.Format = "json"
+		}
+		if config.AccessLog.Format != "json" && config.AccessLog.Format != "combined" {
+			return nil, fmt.Errorf("unknown access log format: %q", config.AccessLog.Format)
+		}
+		if config.AccessLog.MaxSizeMB <= 0 {
+			config.AccessLog.MaxSizeMB = 100
 		}
+		if config.AccessLog.MaxBackups <= 0 {
+			config.AccessLog.MaxBackups = 7
+		}
+		if config.AccessLog.MaxAgeDays <= 0 {
+			config.AccessLog.MaxAgeDays = 30
+		}
+	}
+
+	if config.LameDuckSeconds <= 0 {
+		config.LameDuckSeconds = 5
 	}
 
 	return &config, nil
@@ -85,63 +196,3 @@ func LoadMappingData(filename string) (*MappingData, error) {
 
 	return &mappingData, nil
 }
-
-// BuildPathLookup creates a reverse lookup map from old paths to new URLs
-func BuildPathLookup(mappingData *MappingData) PathLookup {
-	lookup := make(PathLookup)
-
-	for _, mapping := range mappingData.Mappings {
-		// Extract path from old URL (remove protocol and domain if present)
-		oldPath := extractPath(mapping.OldSubscriptionURL)
-
-		// Store the mapping
-		lookup[oldPath] = mapping.NewSubscriptionURL
-	}
-
-	return lookup
-}
-
-// extractPath extracts the path portion from a URL
-// Examples:
-//   - "/sub/user/key" -> "/sub/user/key"
-//   - "https://example.com/sub/user/key" -> "/sub/user/key"
-func extractPath(url string) string {
-	// If URL starts with http:// or https://, extract path
-	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
-		// Find the third slash (after protocol)
-		slashCount := 0
-		for i, char := range url {
-			if char == '/' {
-				slashCount++
-				if slashCount == 3 {
-					return url[i:]
-				}
-			}
-		}
-		// If no path found, return "/"
-		return "/"
-	}
-
-	// Already a path
-	return url
-}
-
-// GetRedirectURL constructs the final redirect URL
-func GetRedirectURL(newURL, redirectDomain, requestScheme, requestHost string) string {
-	// If newURL is absolute (has protocol), use it as-is
-	if strings.HasPrefix(newURL, "http://") || strings.HasPrefix(newURL, "https://") {
-		return newURL
-	}
-
-	// If redirect_domain is specified, use it
-	if redirectDomain != "" {
-		// Ensure redirect_domain has protocol
-		if !strings.HasPrefix(redirectDomain, "http://") && !strings.HasPrefix(redirectDomain, "https://") {
-			redirectDomain = "https://" + redirectDomain
-		}
-		return strings.TrimSuffix(redirectDomain, "/") + newURL
-	}
-
-	// Otherwise, use the request's scheme and host
-	return requestScheme + "://" + requestHost + newURL
-}
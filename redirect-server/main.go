@@ -6,9 +6,11 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -17,18 +19,25 @@ var (
 	configFile  string
 	mappingFile string
 	serverCfg   *ServerConfig
-	pathLookup  PathLookup
+
+	// pathLookupPtr holds the current PathLookup. It is swapped atomically
+	// so redirectHandler never observes a half-built lookup while a reload
+	// is in progress.
+	pathLookupPtr atomic.Pointer[PathLookup]
+
+	// ready gates /healthz: it is flipped to false during lame-duck drain
+	// so upstream load balancers can deregister the instance before it
+	// actually stops accepting connections.
+	ready atomic.Bool
 )
 
-func init() {
+func main() {
 	flag.StringVar(&configFile, "c", "config.json", "Path to the server configuration file")
 	flag.StringVar(&configFile, "config", "config.json", "Path to the server configuration file")
 	flag.StringVar(&mappingFile, "m", "subscription_url_mapping.json", "Path to the URL mapping file")
 	flag.StringVar(&mappingFile, "map", "subscription_url_mapping.json", "Path to the URL mapping file")
 	flag.Parse()
-}
 
-func main() {
 	log.Println("Starting Subscription URL Redirect Server...")
 
 	// Load server configuration
@@ -49,11 +58,37 @@ func main() {
 	log.Printf("Loaded %d user mappings from %s", len(mappingData.Mappings), mappingFile)
 
 	// Build path lookup
-	pathLookup = BuildPathLookup(mappingData)
-	log.Printf("Built path lookup with %d entries", len(pathLookup))
+	pathLookup, err := BuildPathLookup(mappingData)
+	if err != nil {
+		log.Fatalf("Failed to build path lookup: %v", err)
+	}
+	pathLookupPtr.Store(&pathLookup)
+	log.Printf("Built path lookup with %d entries", pathLookup.Len())
 
 	// Setup HTTP handler
-	http.HandleFunc("/", redirectHandler)
+	var redirectRoot http.Handler = http.HandlerFunc(redirectHandler)
+	if serverCfg.AccessLog.Enabled {
+		accessLogger, err := NewAccessLogger(serverCfg.AccessLog)
+		if err != nil {
+			log.Fatalf("Failed to initialize access logger: %v", err)
+		}
+		redirectRoot = accessLogger.Middleware(redirectRoot)
+	}
+	http.Handle("/", redirectRoot)
+	http.HandleFunc("/healthz", healthzHandler)
+
+	if serverCfg.AdminToken != "" {
+		http.Handle("/_admin/", newAdminMux(serverCfg.AdminToken))
+	}
+
+	var acmeManager *ACMEManager
+	if serverCfg.SSL.Enabled && serverCfg.SSL.Mode == SSLModeACME {
+		acmeManager, err = NewACMEManager(serverCfg.SSL)
+		if err != nil {
+			log.Fatalf("Failed to initialize ACME manager: %v", err)
+		}
+		http.Handle(acmeChallengePrefix, acmeManager.ChallengeHandler())
+	}
 
 	// Prepare server address
 	addr := fmt.Sprintf("%s:%d", serverCfg.Host, serverCfg.Port)
@@ -67,28 +102,75 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Bind the listener up front, separately from Serve/ServeTLS, so a
+	// privileged port (e.g. 443) can be acquired before RunAsUser drops
+	// the process's privileges.
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", addr, err)
+	}
+
+	// The ACME HTTP-01 challenge server also needs a privileged port
+	// (:80), so it must be bound here too, before privileges are dropped.
+	var acmeChallengeListener net.Listener
+	if serverCfg.SSL.Enabled && serverCfg.SSL.Mode == SSLModeACME {
+		acmeChallengeListener, err = net.Listen("tcp", ":80")
+		if err != nil {
+			log.Fatalf("Failed to listen on :80 for ACME challenges: %v", err)
+		}
+	}
+
+	if serverCfg.RunAsUser != "" {
+		if err := dropPrivileges(serverCfg.RunAsUser); err != nil {
+			log.Fatalf("Failed to drop privileges to %s: %v", serverCfg.RunAsUser, err)
+		}
+		log.Printf("Dropped privileges to user %s", serverCfg.RunAsUser)
+	}
+
+	ready.Store(true)
+
 	// Start server in a goroutine
 	go func() {
 		if serverCfg.SSL.Enabled {
 			log.Printf("Starting HTTPS server on %s", addr)
 
-			// Create TLS config from embedded cert and key
-			cert, err := tls.X509KeyPair([]byte(serverCfg.SSL.Cert), []byte(serverCfg.SSL.Key))
-			if err != nil {
-				log.Fatalf("Failed to load SSL certificate: %v", err)
-			}
+			switch serverCfg.SSL.Mode {
+			case SSLModeACME:
+				server.TLSConfig = &tls.Config{
+					GetCertificate: acmeManager.GetCertificate,
+					MinVersion:     tls.VersionTLS12,
+				}
+
+				go serveACMEChallenges(acmeManager, acmeChallengeListener)
+			case SSLModeSelfSigned:
+				selfSignedManager, err := NewSelfSignedManager()
+				if err != nil {
+					log.Fatalf("Failed to initialize self-signed dev CA: %v", err)
+				}
+
+				server.TLSConfig = &tls.Config{
+					GetCertificate: selfSignedManager.GetCertificate,
+					MinVersion:     tls.VersionTLS12,
+				}
+			default:
+				// Create TLS config from embedded cert and key
+				cert, err := tls.X509KeyPair([]byte(serverCfg.SSL.Cert), []byte(serverCfg.SSL.Key))
+				if err != nil {
+					log.Fatalf("Failed to load SSL certificate: %v", err)
+				}
 
-			server.TLSConfig = &tls.Config{
-				Certificates: []tls.Certificate{cert},
-				MinVersion:   tls.VersionTLS12,
+				server.TLSConfig = &tls.Config{
+					Certificates: []tls.Certificate{cert},
+					MinVersion:   tls.VersionTLS12,
+				}
 			}
 
-			if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+			if err := server.ServeTLS(listener, "", ""); err != nil && err != http.ErrServerClosed {
 				log.Fatalf("HTTPS server error: %v", err)
 			}
 		} else {
 			log.Printf("Starting HTTP server on %s", addr)
-			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
 				log.Fatalf("HTTP server error: %v", err)
 			}
 		}
@@ -96,11 +178,34 @@ func main() {
 
 	log.Println("Server started successfully. Press Ctrl+C to stop.")
 
-	// Wait for interrupt signal
+	// SIGHUP triggers a reload of the mapping file without restarting the
+	// process; SIGINT/SIGTERM trigger graceful shutdown.
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			log.Println("Received SIGHUP, reloading mappings...")
+			oldCount, newCount, err := reloadMappings()
+			if err != nil {
+				log.Printf("Reload failed, keeping previous %d entries: %v", oldCount, err)
+				continue
+			}
+			log.Printf("Reloaded mappings: %d -> %d entries", oldCount, newCount)
+		}
+	}()
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	<-sigChan
 
+	// Enter lame-duck drain: /healthz starts failing so upstream load
+	// balancers deregister this instance, but the server keeps serving
+	// in-flight and new connections for a bit longer before we shut down.
+	lameDuck := time.Duration(serverCfg.LameDuckSeconds) * time.Second
+	log.Printf("Entering lame-duck drain for %s...", lameDuck)
+	ready.Store(false)
+	time.Sleep(lameDuck)
+
 	log.Println("Shutting down server gracefully...")
 
 	// Graceful shutdown with timeout
@@ -114,19 +219,46 @@ func main() {
 	log.Println("Server stopped")
 }
 
+// healthzHandler reports 200 while the server is accepting traffic and
+// 503 once lame-duck drain has begun.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	if !ready.Load() {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// serveACMEChallenges serves HTTP-01 challenges on ln (bound to :80
+// before any privilege drop, since Let's Encrypt validates them there
+// regardless of which port the HTTPS server listens on). Every other
+// request is redirected to HTTPS.
+func serveACMEChallenges(am *ACMEManager, ln net.Listener) {
+	mux := http.NewServeMux()
+	mux.Handle(acmeChallengePrefix, am.ChallengeHandler())
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://"+r.Host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+
+	log.Println("Starting ACME HTTP-01 challenge server on :80")
+	if err := http.Serve(ln, mux); err != nil && err != http.ErrServerClosed {
+		log.Printf("ACME HTTP challenge server error: %v", err)
+	}
+}
+
 // redirectHandler handles all incoming requests and performs redirects
 func redirectHandler(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path
 
 	// Look up the new URL in the path lookup
-	newURL, found := pathLookup[path]
+	newURL, found := pathLookupPtr.Load().Resolve(path)
 
 	if !found {
-		// No mapping found, return 404
-		log.Printf("404 Not Found: %s", path)
+		lookupMisses.Add(1)
 		http.NotFound(w, r)
 		return
 	}
+	lookupHits.Add(1)
 
 	// Determine request scheme
 	scheme := "http"
@@ -137,11 +269,8 @@ func redirectHandler(w http.ResponseWriter, r *http.Request) {
 	// Get the request host
 	host := r.Host
 
-	// Build the final redirect URL
-	redirectURL := GetRedirectURL(newURL, serverCfg.RedirectDomain, scheme, host)
-
-	// Log the redirect
-	log.Printf("Redirecting: %s -> %s", path, redirectURL)
+	// Build the final redirect URL, preserving the original query string
+	redirectURL := GetRedirectURL(newURL, serverCfg.RedirectDomain, scheme, host, r.URL.RawQuery)
 
 	// Perform 301 Moved Permanently redirect
 	http.Redirect(w, r, redirectURL, http.StatusMovedPermanently)
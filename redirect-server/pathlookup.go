@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MatchType selects how a PathRule's pattern is matched against an
+// incoming request path.
+type MatchType string
+
+const (
+	MatchExact  MatchType = "exact"
+	MatchPrefix MatchType = "prefix"
+	MatchRegex  MatchType = "regex"
+)
+
+// PathRule is one compiled prefix or regex lookup entry. Exact rules are
+// kept separately in PathLookup.Exact for O(1) lookup.
+type PathRule struct {
+	Pattern  string // prefix string, or the regex source for MatchRegex
+	Target   string // redirect target; may reference $1, $2, ... for MatchRegex
+	Regex    *regexp.Regexp
+	Priority int // higher wins ties; see UserMapping.Priority
+}
+
+// PathLookup resolves an old subscription path to its redirect target.
+// Exact matches are served from a map in O(1); everything else falls
+// through to prefix rules (longest first) and then regex rules in
+// descending Priority, ties broken by mapping key. The order in which
+// rules appear in the mapping file is not used.
+type PathLookup struct {
+	Exact  map[string]string
+	Prefix []PathRule
+	Regex  []PathRule
+}
+
+// Resolve returns the redirect target for path, or false if nothing
+// matches. It tries an exact match, then prefix rules longest-first,
+// then regex rules in the order BuildPathLookup sorted them.
+func (pl *PathLookup) Resolve(path string) (string, bool) {
+	if pl == nil {
+		return "", false
+	}
+
+	if target, ok := pl.Exact[path]; ok {
+		return target, true
+	}
+
+	for _, rule := range pl.Prefix {
+		if strings.HasPrefix(path, rule.Pattern) {
+			return rule.Target, true
+		}
+	}
+
+	for _, rule := range pl.Regex {
+		match := rule.Regex.FindStringSubmatchIndex(path)
+		if match == nil {
+			continue
+		}
+		return string(rule.Regex.ExpandString(nil, rule.Target, path, match)), true
+	}
+
+	return "", false
+}
+
+// Len reports the total number of rules across all match kinds.
+func (pl *PathLookup) Len() int {
+	if pl == nil {
+		return 0
+	}
+	return len(pl.Exact) + len(pl.Prefix) + len(pl.Regex)
+}
+
+// BuildPathLookup compiles mappingData into a PathLookup, sorting prefix
+// rules longest-first so the most specific prefix always wins.
+//
+// mappingData.Mappings is a map, so Go's iteration order over it is
+// randomized; rules are first appended sorted by mapping key, making the
+// base order deterministic and stable across repeated loads of the same
+// file (e.g. a SIGHUP/admin reload). That key order is only a tiebreak,
+// though: mapping keys are typically user IDs, so it sorts
+// lexicographically ("10" before "2"), not by file position. Operators
+// who need one regex or equal-length prefix rule to take precedence over
+// another must set UserMapping.Priority explicitly; rules are then
+// stable-sorted by descending Priority on top of the key order.
+func BuildPathLookup(mappingData *MappingData) (PathLookup, error) {
+	lookup := PathLookup{Exact: make(map[string]string)}
+
+	keys := make([]string, 0, len(mappingData.Mappings))
+	for key := range mappingData.Mappings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		mapping := mappingData.Mappings[key]
+		oldPath := extractPath(mapping.OldSubscriptionURL)
+
+		switch MatchType(mapping.MatchType) {
+		case MatchPrefix:
+			lookup.Prefix = append(lookup.Prefix, PathRule{Pattern: oldPath, Target: mapping.NewSubscriptionURL, Priority: mapping.Priority})
+		case MatchRegex:
+			re, err := regexp.Compile(oldPath)
+			if err != nil {
+				return PathLookup{}, fmt.Errorf("invalid regex mapping %q: %w", oldPath, err)
+			}
+			lookup.Regex = append(lookup.Regex, PathRule{Pattern: oldPath, Target: mapping.NewSubscriptionURL, Regex: re, Priority: mapping.Priority})
+		case "", MatchExact:
+			lookup.Exact[oldPath] = mapping.NewSubscriptionURL
+		default:
+			return PathLookup{}, fmt.Errorf("unknown match_type %q for %q", mapping.MatchType, oldPath)
+		}
+	}
+
+	// Stable-sort by Priority first so ties (including the all-zero
+	// default) fall back to the key order established above, then by
+	// prefix length so the longest prefix still always wins regardless of
+	// Priority.
+	sort.SliceStable(lookup.Prefix, func(i, j int) bool {
+		return lookup.Prefix[i].Priority > lookup.Prefix[j].Priority
+	})
+	sort.SliceStable(lookup.Prefix, func(i, j int) bool {
+		return len(lookup.Prefix[i].Pattern) > len(lookup.Prefix[j].Pattern)
+	})
+	sort.SliceStable(lookup.Regex, func(i, j int) bool {
+		return lookup.Regex[i].Priority > lookup.Regex[j].Priority
+	})
+
+	return lookup, nil
+}
+
+// extractPath extracts the path portion from a URL
+// Examples:
+//   - "/sub/user/key" -> "/sub/user/key"
+//   - "https://example.com/sub/user/key" -> "/sub/user/key"
+func extractPath(url string) string {
+	// If URL starts with http:// or https://, extract path
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		// Find the third slash (after protocol)
+		slashCount := 0
+		for i, char := range url {
+			if char == '/' {
+				slashCount++
+				if slashCount == 3 {
+					return url[i:]
+				}
+			}
+		}
+		// If no path found, return "/"
+		return "/"
+	}
+
+	// Already a path
+	return url
+}
+
+// GetRedirectURL constructs the final redirect URL, preserving the
+// original request's query string.
+func GetRedirectURL(newURL, redirectDomain, requestScheme, requestHost, rawQuery string) string {
+	var target string
+	switch {
+	case strings.HasPrefix(newURL, "http://") || strings.HasPrefix(newURL, "https://"):
+		// newURL is already absolute, use it as-is
+		target = newURL
+	case redirectDomain != "":
+		// Ensure redirect_domain has protocol
+		if !strings.HasPrefix(redirectDomain, "http://") && !strings.HasPrefix(redirectDomain, "https://") {
+			redirectDomain = "https://" + redirectDomain
+		}
+		target = strings.TrimSuffix(redirectDomain, "/") + newURL
+	default:
+		// Otherwise, use the request's scheme and host
+		target = requestScheme + "://" + requestHost + newURL
+	}
+
+	if rawQuery == "" {
+		return target
+	}
+
+	separator := "?"
+	if strings.Contains(target, "?") {
+		separator = "&"
+	}
+	return target + separator + rawQuery
+}
@@ -0,0 +1,124 @@
+package main
+
+import "testing"
+
+func TestBuildPathLookupOverlappingPrefixes(t *testing.T) {
+	mappingData := &MappingData{
+		Mappings: map[string]UserMapping{
+			"1": {
+				MatchType:          "prefix",
+				OldSubscriptionURL: "/sub",
+				NewSubscriptionURL: "/new/short",
+			},
+			"2": {
+				MatchType:          "prefix",
+				OldSubscriptionURL: "/sub/user",
+				NewSubscriptionURL: "/new/long",
+			},
+		},
+	}
+
+	lookup, err := BuildPathLookup(mappingData)
+	if err != nil {
+		t.Fatalf("BuildPathLookup: %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/sub/user/clash", "/new/long"},
+		{"/sub/other", "/new/short"},
+	}
+
+	for _, tc := range tests {
+		got, found := lookup.Resolve(tc.path)
+		if !found {
+			t.Errorf("Resolve(%q): no match found", tc.path)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("Resolve(%q) = %q, want %q (longest prefix should win)", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestBuildPathLookupRegexKeyOrderIsDeterministic(t *testing.T) {
+	mappingData := &MappingData{
+		Mappings: map[string]UserMapping{
+			"a": {
+				MatchType:          "regex",
+				OldSubscriptionURL: `^/sub/.*$`,
+				NewSubscriptionURL: "/new/generic",
+			},
+			"b": {
+				MatchType:          "regex",
+				OldSubscriptionURL: `^/sub/special$`,
+				NewSubscriptionURL: "/new/special",
+			},
+		},
+	}
+
+	// Rebuild several times: map iteration order is randomized on every
+	// range over the map, so if the key-sorted tiebreak weren't applied this would
+	// be flaky.
+	for i := 0; i < 20; i++ {
+		lookup, err := BuildPathLookup(mappingData)
+		if err != nil {
+			t.Fatalf("BuildPathLookup: %v", err)
+		}
+
+		got, found := lookup.Resolve("/sub/special")
+		if !found {
+			t.Fatalf("Resolve(%q): no match found", "/sub/special")
+		}
+		// Neither rule sets Priority, so both are equal (0) and fall back
+		// to key order: "a" sorts before "b", so the generic rule wins
+		// even though "b" is the more specific match.
+		if got != "/new/generic" {
+			t.Fatalf("Resolve(%q) = %q, want %q (key-sorted regex rule should win on equal priority)", "/sub/special", got, "/new/generic")
+		}
+	}
+}
+
+func TestBuildPathLookupRegexPriorityControlsPrecedence(t *testing.T) {
+	mappingData := &MappingData{
+		Mappings: map[string]UserMapping{
+			"a": {
+				MatchType:          "regex",
+				OldSubscriptionURL: `^/sub/.*$`,
+				NewSubscriptionURL: "/new/generic",
+			},
+			"b": {
+				MatchType:          "regex",
+				OldSubscriptionURL: `^/sub/special$`,
+				NewSubscriptionURL: "/new/special",
+				Priority:           1,
+			},
+		},
+	}
+
+	// "b" is the more specific rule and would otherwise lose to "a" on
+	// key order alone (see TestBuildPathLookupRegexKeyOrderIsDeterministic);
+	// setting Priority gives the operator a real lever to override that.
+	lookup, err := BuildPathLookup(mappingData)
+	if err != nil {
+		t.Fatalf("BuildPathLookup: %v", err)
+	}
+
+	got, found := lookup.Resolve("/sub/special")
+	if !found {
+		t.Fatalf("Resolve(%q): no match found", "/sub/special")
+	}
+	if got != "/new/special" {
+		t.Fatalf("Resolve(%q) = %q, want %q (higher Priority rule should win)", "/sub/special", got, "/new/special")
+	}
+}
+
+func TestGetRedirectURLPreservesQueryString(t *testing.T) {
+	got := GetRedirectURL("/new/path", "", "https", "example.com", "foo=bar")
+	want := "https://example.com/new/path?foo=bar"
+	if got != want {
+		t.Errorf("GetRedirectURL = %q, want %q", got, want)
+	}
+}
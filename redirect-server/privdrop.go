@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// dropPrivileges switches the running process to username's uid and
+// primary gid. It must only be called after any privileged listener
+// (e.g. port 443) has already been bound, since binding it requires the
+// original privileges but serving from it does not.
+func dropPrivileges(username string) error {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return fmt.Errorf("lookup user %q: %w", username, err)
+	}
+
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return fmt.Errorf("parse gid for %q: %w", username, err)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("parse uid for %q: %w", username, err)
+	}
+
+	// Drop supplementary groups (e.g. root) before changing the primary
+	// uid/gid, otherwise the process keeps whatever group memberships it
+	// started with even after Setuid/Setgid.
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("setgroups(%d): %w", gid, err)
+	}
+
+	// Group must be dropped before the user: once the uid changes, the
+	// process will no longer be permitted to change its gid.
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid(%d): %w", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid(%d): %w", uid, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// selfSignedLeafLifetime is how long minted leaf certificates remain valid.
+const selfSignedLeafLifetime = 24 * time.Hour
+
+// SelfSignedManager mints leaf certificates on demand for arbitrary SNI
+// hosts, signed by an ephemeral in-memory CA generated at startup. It
+// exists purely so contributors can exercise the server's TLS path
+// against arbitrary Host headers while testing mapping files, without
+// generating or disabling real certificates.
+type SelfSignedManager struct {
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+
+	leaves     sync.Map // host (string) -> *tls.Certificate
+	nextSerial atomic.Int64
+}
+
+// NewSelfSignedManager generates an ephemeral CA and prints its PEM
+// encoding to stdout once, so developers can trust it locally if their
+// client verifies certificates.
+func NewSelfSignedManager() (*SelfSignedManager, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate dev CA key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate dev CA serial: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "PasarGuard Dev CA", Organization: []string{"PasarGuard"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to self-sign dev CA: %w", err)
+	}
+
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse dev CA certificate: %w", err)
+	}
+
+	log.Println("Generated ephemeral dev CA for selfsigned SSL mode; trust it locally if your client verifies certificates:")
+	fmt.Println(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+
+	return &SelfSignedManager{caCert: caCert, caKey: caKey}, nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate: it serves a cached
+// leaf certificate for hello.ServerName, minting one on first use.
+func (m *SelfSignedManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := hello.ServerName
+	if host == "" {
+		host = "localhost"
+	}
+
+	if cached, ok := m.leaves.Load(host); ok {
+		if cert := cached.(*tls.Certificate); certValidFor(cert, time.Hour) {
+			return cert, nil
+		}
+	}
+
+	cert, err := m.mintLeaf(host)
+	if err != nil {
+		return nil, err
+	}
+
+	m.leaves.Store(host, cert)
+	return cert, nil
+}
+
+// mintLeaf signs a fresh leaf certificate for host with the dev CA.
+func (m *SelfSignedManager) mintLeaf(host string) (*tls.Certificate, error) {
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate leaf key for %s: %w", host, err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(m.nextSerial.Add(1)),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(selfSignedLeafLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, m.caCert, &leafKey.PublicKey, m.caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mint leaf certificate for %s: %w", host, err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse minted certificate for %s: %w", host, err)
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, m.caCert.Raw},
+		PrivateKey:  leafKey,
+		Leaf:        leaf,
+	}, nil
+}